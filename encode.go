@@ -0,0 +1,230 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Encoder writes a parsed set of Mork tables out in some format.
+type Encoder interface {
+	Encode(tabs map[string]Table, w io.Writer) error
+}
+
+// JSONEncoder writes tabs out as a single JSON object, keyed by toid.
+type JSONEncoder struct{}
+
+func (JSONEncoder) Encode(tabs map[string]Table, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(tabs)
+}
+
+// CSVEncoder writes one CSV file per table into a directory, with a
+// column for each key seen across that table's rows. It ignores the
+// io.Writer passed to Encode, since it produces several files rather than
+// a single stream.
+type CSVEncoder struct {
+	Dir string
+}
+
+func NewCSVEncoder(dir string) *CSVEncoder {
+	return &CSVEncoder{Dir: dir}
+}
+
+func (e *CSVEncoder) Encode(tabs map[string]Table, _ io.Writer) error {
+	if err := os.MkdirAll(e.Dir, 0755); err != nil {
+		return err
+	}
+	for toid, tab := range tabs {
+		if err := e.encodeTable(toid, tab); err != nil {
+			return fmt.Errorf("table %s: %w", toid, err)
+		}
+	}
+	return nil
+}
+
+func (e *CSVEncoder) encodeTable(toid string, tab Table) error {
+	cols := columnUnion(tab.rows)
+
+	f, err := os.Create(filepath.Join(e.Dir, sanitizeFilename(toid)+".csv"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cw := csv.NewWriter(f)
+	if err := cw.Write(append([]string{roidColumnName(cols)}, cols...)); err != nil {
+		return err
+	}
+	for _, roid := range rowOrder(tab) {
+		row := tab.rows[roid]
+		rec := make([]string, 0, len(cols)+1)
+		rec = append(rec, roid)
+		for _, col := range cols {
+			rec = append(rec, row[col])
+		}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// rowOrder returns the roids of tab's rows in the order they should be
+// encoded: file order if the table was parsed with PreserveOrder, or
+// Go's (unspecified) map iteration order otherwise.
+func rowOrder(tab Table) []string {
+	if order := tab.RowOrder(); order != nil {
+		return order
+	}
+	ids := make([]string, 0, len(tab.rows))
+	for roid := range tab.rows {
+		ids = append(ids, roid)
+	}
+	return ids
+}
+
+// SQLiteEncoder writes one SQLite table per Mork table into the database
+// at Path, with a column for each key seen across that table's rows. It
+// ignores the io.Writer passed to Encode, since the output is a database
+// file rather than a stream.
+type SQLiteEncoder struct {
+	Path string
+}
+
+func NewSQLiteEncoder(path string) *SQLiteEncoder {
+	return &SQLiteEncoder{Path: path}
+}
+
+func (e *SQLiteEncoder) Encode(tabs map[string]Table, _ io.Writer) error {
+	db, err := sql.Open("sqlite3", e.Path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for toid, tab := range tabs {
+		if err := e.encodeTable(db, toid, tab); err != nil {
+			return fmt.Errorf("table %s: %w", toid, err)
+		}
+	}
+	return nil
+}
+
+func (e *SQLiteEncoder) encodeTable(db *sql.DB, toid string, tab Table) error {
+	name := sanitizeFilename(toid)
+	cols := columnUnion(tab.rows)
+	roidCol := roidColumnName(cols)
+
+	colDefs := []string{fmt.Sprintf("%s TEXT PRIMARY KEY", quoteSQLIdent(roidCol))}
+	for _, col := range cols {
+		colDefs = append(colDefs, fmt.Sprintf("%s TEXT", quoteSQLIdent(col)))
+	}
+	ddl := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (%s)`,
+		quoteSQLIdent(name), strings.Join(colDefs, ", "))
+	if _, err := db.Exec(ddl); err != nil {
+		return err
+	}
+
+	placeholders := make([]string, len(cols)+1)
+	for i := range placeholders {
+		placeholders[i] = "?"
+	}
+	insert := fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`,
+		quoteSQLIdent(name), quotedIdents(append([]string{roidCol}, cols...)), strings.Join(placeholders, ", "))
+
+	for roid, row := range tab.rows {
+		args := make([]interface{}, 0, len(cols)+1)
+		args = append(args, roid)
+		for _, col := range cols {
+			args = append(args, row[col])
+		}
+		if _, err := db.Exec(insert, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columnUnion returns the sorted set of column names used across rows.
+func columnUnion(rows map[string]Row) []string {
+	seen := map[string]bool{}
+	var cols []string
+	for _, row := range rows {
+		for col := range row {
+			if !seen[col] {
+				seen[col] = true
+				cols = append(cols, col)
+			}
+		}
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+// roidColumnName picks a name for the synthetic column holding each row's
+// roid, avoiding a collision with an actual cell named "roid" - column
+// names come from dict-resolved Mork cell names, which (like the toids
+// sanitizeFilename deals with) can be arbitrary attacker/file-controlled
+// text.
+func roidColumnName(cols []string) string {
+	name := "roid"
+	for {
+		collides := false
+		for _, col := range cols {
+			if col == name {
+				collides = true
+				break
+			}
+		}
+		if !collides {
+			return name
+		}
+		name = "_" + name
+	}
+}
+
+// sanitizeFilename reduces a toid to a safe bare filename/SQL-identifier
+// fragment. toids come from dict-resolved Mork strings, which can be
+// arbitrary attacker-controlled text (including "/" and ".."), so this
+// keeps an allowlist of characters rather than blocking specific ones.
+func sanitizeFilename(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// quoteSQLIdent quotes name as a SQL identifier (not a string literal),
+// doubling any embedded quote - unlike fmt's %q, which backslash-escapes
+// for Go syntax and would let a crafted identifier break out of the quotes.
+func quoteSQLIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func quotedIdents(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = quoteSQLIdent(n)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCSVEncoderPreservesRowOrder(t *testing.T) {
+	tabs := map[string]Table{
+		"1:c": {
+			rows:     map[string]Row{"3": {"Name": "Carl"}, "1": {"Name": "Ada"}, "2": {"Name": "Bob"}},
+			rowOrder: []string{"3", "1", "2"},
+		},
+	}
+
+	dir := t.TempDir()
+	if err := NewCSVEncoder(dir).Encode(tabs, nil); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "1_c.csv"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if len(records) != 4 { // header + 3 rows
+		t.Fatalf("got %d records, want 4: %v", len(records), records)
+	}
+	want := []string{"3", "1", "2"}
+	for i, roid := range want {
+		if got := records[i+1][0]; got != roid {
+			t.Errorf("row %d roid = %q, want %q (PreserveOrder not respected)", i, got, roid)
+		}
+	}
+}
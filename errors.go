@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Position identifies a location in a Mork source file.
+type Position struct {
+	Filename string
+	Line     int
+	Column   int
+}
+
+func (pos Position) String() string {
+	s := pos.Filename
+	if s == "" {
+		s = "<input>"
+	}
+	if pos.Line > 0 {
+		s = fmt.Sprintf("%s:%d", s, pos.Line)
+		if pos.Column > 0 {
+			s = fmt.Sprintf("%s:%d", s, pos.Column)
+		}
+	}
+	return s
+}
+
+// Error is a single parse error, tagged with the position it occurred at.
+type Error struct {
+	Pos Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// ErrorList is a list of *Error. It implements the error interface, in the
+// same style as go/scanner.ErrorList - a parser can accumulate as many
+// errors as it likes and hand the whole list back as a single error value.
+type ErrorList []*Error
+
+// Add appends an error at the given position.
+func (l *ErrorList) Add(pos Position, format string, args ...interface{}) {
+	*l = append(*l, &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (l ErrorList) Len() int      { return len(l) }
+func (l ErrorList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l ErrorList) Less(i, j int) bool {
+	a, b := l[i].Pos, l[j].Pos
+	if a.Filename != b.Filename {
+		return a.Filename < b.Filename
+	}
+	if a.Line != b.Line {
+		return a.Line < b.Line
+	}
+	return a.Column < b.Column
+}
+
+// Sort sorts the list in place by source position.
+func (l ErrorList) Sort() { sort.Sort(l) }
+
+// Err returns l as an error, or nil if l is empty. Use this rather than
+// returning an ErrorList directly, so that a clean parse yields a nil error.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// Error renders the first error in the list, plus a count of the rest.
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
@@ -116,13 +116,20 @@ func newLexer(input []byte) *lexer {
 	return l
 }
 
-// nextItem returns the next item from the input.
+// nextItem returns the next item from the input. Once the input is
+// exhausted, it keeps returning tEOF on every subsequent call rather than
+// panicking - a parser recovering from an error at or past EOF (see
+// parser.sync) may need to ask for "the next token" more than once after
+// the stream has already ended.
 func (l *lexer) nextItem() item {
 	for {
 		select {
 		case item := <-l.items:
 			return item
 		default:
+			if l.state == nil {
+				return item{typ: tEOF, pos: l.curr}
+			}
 			l.state = l.state(l)
 		}
 	}
@@ -166,6 +173,18 @@ func (l *lexer) emit(t itemType) {
 	l.start = l.curr
 }
 
+// emitVal outputs an item with an explicit value, overriding the raw text
+// consumed so far (used when the interesting part of a token is only a
+// substring of what was matched, eg. the ID inside a group marker).
+func (l *lexer) emitVal(t itemType, val string) {
+	l.items <- item{
+		typ: t,
+		val: val,
+		pos: l.start,
+	}
+	l.start = l.curr
+}
+
 // emitErrorf emits an error
 func (l *lexer) emitErrorf(format string, args ...interface{}) {
 	l.items <- item{
@@ -251,7 +270,8 @@ func lexDefault(l *lexer) stateFn {
 	}
 }
 
-// lexLiteral scans from '=' to end of literal.
+// lexLiteral scans from '=' to end of literal, decoding Mork's escapes
+// along the way.
 func lexLiteral(l *lexer) stateFn {
 	r := l.next()
 	if r != '=' {
@@ -262,7 +282,8 @@ func lexLiteral(l *lexer) stateFn {
 	// spit out '=' as a separate token
 	l.emit(tEQUAL)
 
-	// don't do escaping here, but need to track the escape char to know when literal ends.
+	// track the escape char so we know when the literal actually ends -
+	// an escaped ')' doesn't terminate it.
 	esc := false
 	for {
 		r := l.peek()
@@ -279,10 +300,56 @@ func lexLiteral(l *lexer) stateFn {
 		l.next()
 	}
 
-	l.emit(tLITERAL)
+	raw := l.input[l.start.offset:l.curr.offset]
+	l.emitVal(tLITERAL, decodeLiteral(raw))
 	return lexDefault
 }
 
+// decodeLiteral decodes the escapes used inside a Mork literal:
+//   - "\" followed by a newline (LF, or CRLF) is a line continuation and
+//     is dropped entirely.
+//   - "\" followed by any other byte escapes that byte literally (used
+//     for "\$", "\\", "\)" and friends).
+//   - "$XX" is a byte given as two hex digits (used for non-ASCII bytes,
+//     and for bytes that would otherwise be read as delimiters).
+func decodeLiteral(raw []byte) string {
+	out := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '\\' && i+1 < len(raw) && raw[i+1] == '\n':
+			i++ // drop "\" + LF
+		case c == '\\' && i+2 < len(raw) && raw[i+1] == '\r' && raw[i+2] == '\n':
+			i += 2 // drop "\" + CRLF
+		case c == '\\' && i+1 < len(raw):
+			i++
+			out = append(out, raw[i])
+		case c == '$' && i+2 < len(raw) && isHex(rune(raw[i+1])) && isHex(rune(raw[i+2])):
+			out = append(out, hexByte(rune(raw[i+1]), rune(raw[i+2])))
+			i += 2
+		default:
+			out = append(out, c)
+		}
+	}
+	return string(out)
+}
+
+// hexByte decodes a byte from two hex digits.
+func hexByte(hi, lo rune) byte {
+	return hexDigit(hi)<<4 | hexDigit(lo)
+}
+
+func hexDigit(r rune) byte {
+	switch {
+	case r >= '0' && r <= '9':
+		return byte(r - '0')
+	case r >= 'a' && r <= 'f':
+		return byte(r-'a') + 10
+	default: // r >= 'A' && r <= 'F', already verified by isHex
+		return byte(r-'A') + 10
+	}
+}
+
 func lexComment(l *lexer) stateFn {
 	r := l.next()
 	if r != '/' {
@@ -363,7 +430,9 @@ func lexGroup(l *lexer) stateFn {
 		if !l.expect("{@") {
 			return nil
 		}
-		l.emit(tGROUPSTART)
+		// val is just the ID, so the parser can match it against the
+		// commit/abort that closes the group.
+		l.emitVal(tGROUPSTART, id)
 		return lexDefault
 	case '}':
 		// end of group.
@@ -389,7 +458,7 @@ func lexGroup(l *lexer) stateFn {
 		if !l.expect("}@") {
 			return nil
 		}
-		l.emit(tGROUPCOMMIT)
+		l.emitVal(tGROUPCOMMIT, id)
 		return lexDefault
 	}
 
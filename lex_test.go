@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestDecodeLiteral(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "hello", "hello"},
+		{"escaped paren", `hello\)world`, "hello)world"},
+		{"escaped backslash", `a\\b`, `a\b`},
+		{"escaped dollar", `a\$b`, "a$b"},
+		{"line continuation LF", "abc\\\ndef", "abcdef"},
+		{"line continuation CRLF", "abc\\\r\ndef", "abcdef"},
+		{"hex byte", "a$41b", "aAb"},
+		// Thunderbird address-book entries store non-ASCII as $XX - eg.
+		// "Jos\xc3\xa9" (UTF-8 for "José") comes out as "Jos$C3$A9".
+		{"utf8 via hex", "Jos$C3$A9", "José"},
+		{"dollar not hex stays literal", "a$zzb", "a$zzb"},
+	}
+
+	for _, c := range cases {
+		got := decodeLiteral([]byte(c.in))
+		if got != c.want {
+			t.Errorf("%s: decodeLiteral(%q) = %q, want %q", c.name, c.in, got, c.want)
+		}
+	}
+}
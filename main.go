@@ -3,26 +3,43 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 )
 
 func main() {
-
+	format := flag.String("format", "json", "output format: json, csv or sqlite")
+	out := flag.String("out", "", "output path (file for json/sqlite, directory for csv); defaults to stdout for json")
 	flag.Parse()
 
+	enc, w, closeOut, err := encoderFor(*format, *out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	if closeOut != nil {
+		defer closeOut()
+	}
+
 	for _, inFilename := range flag.Args() {
 		tabs, err := slurp(inFilename)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
-			os.Exit(1)
+			// slurp parses in Recover mode, so a parse failure comes back
+			// as an ErrorList alongside whatever tabs were recovered -
+			// report it but still encode the partial result. Anything
+			// else (eg. the file couldn't be read at all) is fatal.
+			if _, ok := err.(ErrorList); !ok {
+				fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "WARNING: %s\n", err)
 		}
-		err = output(tabs)
+		err = enc.Encode(tabs, w)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
 			os.Exit(1)
 		}
-
 	}
 }
 
@@ -33,7 +50,7 @@ func slurp(filename string) (map[string]Table, error) {
 	}
 
 	l := newLexer(raw)
-	p := NewParser(filename, l)
+	p := NewParser(filename, l, Recover)
 	return p.Parse()
 }
 
@@ -56,17 +73,32 @@ func dumpToks(filename string) error {
 	}
 }
 
-func output(tabs map[string]Table) error {
-
-	for toid, tab := range tabs {
-		fmt.Printf("----- %s -----\n", toid)
-		for roid, row := range tab.rows {
-			fmt.Printf("  row %s:\n", roid)
-			for name, value := range row {
-				fmt.Printf("    %s: '%s'\n", name, value)
-			}
+// encoderFor builds the Encoder named by format, along with the
+// io.Writer it should use (only meaningful for formats that write a
+// single stream, like json) and an optional close function to run once
+// all input files have been processed.
+func encoderFor(format, out string) (Encoder, io.Writer, func(), error) {
+	switch format {
+	case "json":
+		if out == "" {
+			return JSONEncoder{}, os.Stdout, nil, nil
+		}
+		f, err := os.Create(out)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return JSONEncoder{}, f, func() { f.Close() }, nil
+	case "csv":
+		if out == "" {
+			return nil, nil, nil, fmt.Errorf("-out=<dir> is required for -format=csv")
 		}
+		return NewCSVEncoder(out), ioutil.Discard, nil, nil
+	case "sqlite":
+		if out == "" {
+			return nil, nil, nil, fmt.Errorf("-out=<path> is required for -format=sqlite")
+		}
+		return NewSQLiteEncoder(out), ioutil.Discard, nil, nil
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown -format %q", format)
 	}
-
-	return nil
 }
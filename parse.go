@@ -1,61 +1,237 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 )
 
 type Row map[string]string
 
 type Table struct {
-	meta map[string]string `json:"meta,omitempty"`
-	rows map[string]Row    `json:"rows,omitempty"`
+	meta map[string]string
+	rows map[string]Row
+
+	// rowOrder records roids in the order they were first seen, and is
+	// only populated when the parser's PreserveOrder mode is set.
+	rowOrder []string
+}
+
+// MarshalJSON lets Table's unexported fields be encoded, since the
+// encoding/json package can't see them directly.
+func (t Table) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Meta map[string]string `json:"meta,omitempty"`
+		Rows map[string]Row    `json:"rows,omitempty"`
+	}{t.meta, t.rows})
+}
+
+// RowOrder returns the table's roids in file order, or nil if the table
+// wasn't parsed with PreserveOrder - ranging over Rows directly loses
+// that order, since Go map iteration isn't stable.
+func (t Table) RowOrder() []string {
+	return t.rowOrder
 }
 
+// Mode is a set of flags controlling parser behaviour, in the same
+// spirit as go/parser's Mode bitset.
+type Mode uint
+
+const (
+	// Recover tells the parser to keep going after a syntax error, instead
+	// of aborting on the first one. It skips forward to the next sync point
+	// ('}', ']', '>' or a group commit/abort) and carries on, accumulating
+	// errors along the way. This is the only way to get anything useful out
+	// of real-world Mork files, which are frequently truncated mid-group.
+	Recover Mode = 1 << iota
+
+	// Trace prints the entry and exit of the dict, table, row, cell, ref,
+	// oid and group productions, indented by nesting depth - handy for
+	// working out why a malformed file parses the way it does. The helpers
+	// expectMetadict, expectCells, expectName and expectID are not traced.
+	Trace
+
+	// SkipMetaTables skips over metatables ("{ (cell)* }" right after a
+	// toid) without retaining their cells, rather than collecting them
+	// into Table.meta. Callers that don't use table metadata can use this
+	// to avoid the work.
+	SkipMetaTables
+
+	// StrictHex rejects IDs that aren't all hex digits. It's off by
+	// default, since real-world files occasionally have odd ids that are
+	// otherwise harmless to carry through as opaque strings.
+	StrictHex
+
+	// PreserveOrder records each table's roids in file order, retrievable
+	// via Table.RowOrder - without it, row order is whatever Go's map
+	// iteration happens to produce.
+	PreserveOrder
+)
+
 type parser struct {
 	filename string
 	lex      *lexer
+	mode     Mode
 	peeked   *item // if we've peeked a token, keep it here
-	// err holds the last error. To save error checking, the expect*() fns are
-	// all basically no-ops if this is set. So we don't need to check errors
-	// after every step.
+
+	traceIndent int // current nesting depth, for Trace mode
+
+	// err holds the error (if any) for the production currently being
+	// parsed. To save error checking, the expect*() fns are all basically
+	// no-ops if this is set. So we don't need to check errors after every
+	// step. It's cleared by sync() when recovering from a syntax error.
 	err error
 
+	// errs accumulates every error seen over the life of the parser.
+	errs ErrorList
+
 	// a map of dicts, keyed by namespace
 	dicts map[string]map[string]string
+
+	// txns is a stack of in-progress group transactions. Nested groups
+	// push onto it; empty means we're not inside a group at all.
+	txns []*txn
+}
+
+// txn is a staging area for the changes made while parsing a
+// "@$${id{@ ... @$$}id}@" group. Dict updates and tables are buffered
+// here, copy-on-write, rather than applied directly - so a tGROUPABORT
+// (or a group left dangling at EOF) can simply be discarded without
+// undoing anything visible outside the group.
+type txn struct {
+	dicts map[string]map[string]string
+	tabs  map[string]Table
+}
+
+func newTxn() *txn {
+	return &txn{
+		dicts: make(map[string]map[string]string),
+		tabs:  make(map[string]Table),
+	}
 }
 
-func NewParser(filename string, lex *lexer) *parser {
-	return &parser{filename: filename, lex: lex,
+func NewParser(filename string, lex *lexer, mode Mode) *parser {
+	return &parser{filename: filename, lex: lex, mode: mode,
 		dicts: make(map[string]map[string]string),
 	}
 }
 
-// dict returns the dictionary for the given namespace, creating it if required.
+// Errors returns all the errors accumulated so far.
+func (p *parser) Errors() ErrorList {
+	return p.errs
+}
+
+// trace prints entry into a production when Mode has Trace set, and
+// returns a function to call on exit - mirroring the trace/un pattern
+// from go/parser.
+func (p *parser) trace(name string) func() {
+	if p.mode&Trace == 0 {
+		return func() {}
+	}
+	tok := p.peekTok()
+	fmt.Printf("%s%d:%d: %s (\n", strings.Repeat(". ", p.traceIndent), tok.pos.line, tok.pos.col, name)
+	p.traceIndent++
+	return func() {
+		p.traceIndent--
+		fmt.Printf("%s)\n", strings.Repeat(". ", p.traceIndent))
+	}
+}
+
+// errorf records an error at the given position, and marks the current
+// production as failed.
+func (p *parser) errorf(pos position, format string, args ...interface{}) {
+	p.errs.Add(Position{Filename: p.filename, Line: pos.line, Column: pos.col}, format, args...)
+	if p.err == nil {
+		p.err = p.errs[len(p.errs)-1]
+	}
+}
+
+// dict returns the writable dictionary for the given namespace, in the
+// current scope. Inside a group, this is the top transaction frame: the
+// first write clones whatever was visible from the enclosing scope, so
+// the group's changes stay isolated until it commits.
 func (p *parser) dict(namespace string) map[string]string {
-	var dict map[string]string
-	var ok bool
-	if dict, ok = p.dicts[namespace]; !ok {
-		dict = make(map[string]string)
-		p.dicts[namespace] = dict
+	if len(p.txns) == 0 {
+		dict, ok := p.dicts[namespace]
+		if !ok {
+			dict = make(map[string]string)
+			p.dicts[namespace] = dict
+		}
+		return dict
+	}
+
+	top := p.txns[len(p.txns)-1]
+	if dict, ok := top.dicts[namespace]; ok {
+		return dict
 	}
-	return dict
+	clone := make(map[string]string)
+	for k, v := range p.readDict(namespace) {
+		clone[k] = v
+	}
+	top.dicts[namespace] = clone
+	return clone
+}
+
+// readDict returns the dictionary currently visible for namespace, without
+// staging a copy-on-write clone: the top-most group frame that has touched
+// the namespace, falling back through enclosing frames to the committed
+// dicts. May return nil if namespace hasn't been seen anywhere.
+func (p *parser) readDict(namespace string) map[string]string {
+	for i := len(p.txns) - 1; i >= 0; i-- {
+		if dict, ok := p.txns[i].dicts[namespace]; ok {
+			return dict
+		}
+	}
+	return p.dicts[namespace]
 }
 
 // resolve returns the string currently stored for the id:namespace pair.
-// If not found, returns "" and sets p.err
-func (p *parser) resolve(id, namespace string) string {
+// If not found, returns "" and records an error at pos.
+func (p *parser) resolve(id, namespace string, pos position) string {
 	if p.err != nil {
 		return ""
 	}
-	dict := p.dict(namespace)
-	full, ok := dict[id]
+	full, ok := p.readDict(namespace)[id]
 	if !ok {
-		p.err = fmt.Errorf("%s: bad alias %s:%s", p.filename, id, namespace)
+		p.errorf(pos, "bad alias %s:%s", id, namespace)
 		return ""
 	}
 	return full
 }
 
+// pushTxn starts a new group transaction frame.
+func (p *parser) pushTxn() *txn {
+	t := newTxn()
+	p.txns = append(p.txns, t)
+	return t
+}
+
+// commitTxn pops the top transaction frame, merging its dict changes into
+// the enclosing scope (the parser's base dicts, or the parent frame if this
+// was a nested group), and returns the tables it staged.
+func (p *parser) commitTxn() map[string]Table {
+	t := p.txns[len(p.txns)-1]
+	p.txns = p.txns[:len(p.txns)-1]
+
+	if len(p.txns) == 0 {
+		for ns, d := range t.dicts {
+			p.dicts[ns] = d
+		}
+	} else {
+		parent := p.txns[len(p.txns)-1]
+		for ns, d := range t.dicts {
+			parent.dicts[ns] = d
+		}
+	}
+	return t.tabs
+}
+
+// abortTxn pops and discards the top transaction frame.
+func (p *parser) abortTxn() map[string]Table {
+	p.txns = p.txns[:len(p.txns)-1]
+	return map[string]Table{}
+}
+
 // peekTok returns the next token in the input, without consuming it.
 func (p *parser) peekTok() item {
 	if p.peeked == nil {
@@ -78,13 +254,19 @@ func (p *parser) nextTok() item {
 	return tok
 }
 
+// Parse reads the whole input, returning the tables found. In fail-fast
+// mode (the default) it stops at the first error. With the Recover mode
+// flag set, it skips forward to the next sync point and keeps going,
+// returning every table it read (including any cut short by an error)
+// along with the accumulated errors. The returned error is nil, or an
+// ErrorList.
 func (p *parser) Parse() (map[string]Table, error) {
 	tabs := make(map[string]Table)
 	for {
 		tok := p.peekTok()
 		switch tok.typ {
 		case tEOF:
-			return tabs, p.err // Done!
+			return tabs, p.errs.Err()
 		case tLANGLE:
 			p.expectDict()
 		case tLSQUARE:
@@ -96,13 +278,35 @@ func (p *parser) Parse() (map[string]Table, error) {
 			toid, tab := p.expectTable()
 			tabs[toid] = tab
 		case tGROUPSTART:
-			_ = p.expectGroup()
+			for toid, tab := range p.expectGroup() {
+				tabs[toid] = tab
+			}
 		default:
-			p.err = fmt.Errorf("%s: Unexpected %s", p.filename, tok)
+			p.errorf(tok.pos, "Unexpected %s", &tok)
 		}
 
 		if p.err != nil {
-			return tabs, p.err
+			if p.mode&Recover == 0 {
+				return tabs, p.errs.Err()
+			}
+			p.sync()
+		}
+	}
+}
+
+// sync skips tokens until it reaches a known sync point - the close of
+// some enclosing construct ('}', ']', '>') or the end of a group - and
+// clears p.err so parsing can resume from there. It's only used in
+// Recover mode.
+func (p *parser) sync() {
+	p.err = nil
+	for {
+		tok := p.nextTok()
+		switch tok.typ {
+		case tEOF:
+			return
+		case tRBRACE, tRSQUARE, tRANGLE, tGROUPCOMMIT, tGROUPABORT:
+			return
 		}
 	}
 }
@@ -116,8 +320,7 @@ func (p *parser) expect(expected itemType) item {
 	}
 	tok := p.nextTok()
 	if tok.typ != expected {
-		p.err = fmt.Errorf("%s: Unexpected - %s", p.filename, &tok)
-		panic("poop")
+		p.errorf(tok.pos, "Unexpected - %s", &tok)
 		return item{}
 	}
 	return tok
@@ -129,6 +332,7 @@ func (p *parser) expect(expected itemType) item {
 //    value ::= ^oid | =literal
 //    oid ::= id | id:scope
 func (p *parser) expectDict() {
+	defer p.trace("expectDict")()
 	p.expect(tLANGLE)
 	scope := "a"
 
@@ -172,6 +376,7 @@ func (p *parser) expectName() string {
 //  slot ::= ref /*default scope is a*/ | =literal
 //  ref ::= ^id | ^id:scope
 func (p *parser) expectCell() (string, string) {
+	defer p.trace("expectCell")()
 	p.expect(tLPAREN)
 
 	var name, value string
@@ -188,7 +393,6 @@ func (p *parser) expectCell() (string, string) {
 	if tok.typ == tEQUAL {
 		p.nextTok()
 		value = p.expect(tLITERAL).val
-		// TODO: ESCAPING!!!
 	} else {
 		value = p.expectRef("a")
 	}
@@ -219,6 +423,7 @@ func (p *parser) expectCells() map[string]string {
 
 // expectRow parses a row, returning the row ID (roid) and the cells.
 func (p *parser) expectRow(rowScope string) (string, map[string]string) {
+	defer p.trace("expectRow")()
 	p.expect(tLSQUARE)
 	roid, _ := p.expectOID(rowScope)
 	// TODO: handle optional metarow???
@@ -227,17 +432,21 @@ func (p *parser) expectRow(rowScope string) (string, map[string]string) {
 	return roid, cells
 }
 
-// expectID fetches the next token as a hex ID
+// expectID fetches the next token as an ID. In StrictHex mode, non-hex
+// IDs are rejected; otherwise they're passed through as opaque strings,
+// since a malformed real-world file is more useful partially read than
+// not read at all.
 func (p *parser) expectID() string {
 	tok := p.expect(tNAME)
 	if p.err != nil {
 		return ""
 	}
-	// Fail if it's not all hex.
-	for _, r := range tok.val {
-		if !isHex(r) {
-			p.err = fmt.Errorf("%s: Not hex - %s", p.filename, &tok)
-			return ""
+	if p.mode&StrictHex != 0 {
+		for _, r := range tok.val {
+			if !isHex(r) {
+				p.errorf(tok.pos, "Not hex - %s", &tok)
+				return ""
+			}
 		}
 	}
 	return tok.val
@@ -245,7 +454,8 @@ func (p *parser) expectID() string {
 
 // ^id:ns
 func (p *parser) expectRef(defaultNamespace string) string {
-	p.expect(tCARET)
+	defer p.trace("expectRef")()
+	caret := p.expect(tCARET)
 	id := p.expectID()
 	tok := p.peekTok()
 	ns := defaultNamespace
@@ -254,7 +464,7 @@ func (p *parser) expectRef(defaultNamespace string) string {
 		tok = p.expect(tNAME)
 		ns = tok.val
 	}
-	return p.resolve(id, ns)
+	return p.resolve(id, ns, caret.pos)
 }
 
 // Oids/Mids
@@ -265,6 +475,7 @@ func (p *parser) expectRef(defaultNamespace string) string {
 //    scope ::= name | ^id | ^oid
 
 func (p *parser) expectOID(defaultNamespace string) (string, string) {
+	defer p.trace("expectOID")()
 	name := p.expectID()
 	scope := ""
 	// is there a scope?
@@ -287,6 +498,7 @@ func (p *parser) expectOID(defaultNamespace string) (string, string) {
 //  toid ::= oid /*default scope is c*/
 //  metatable ::= { (cell)* }
 func (p *parser) expectTable() (string, Table) {
+	defer p.trace("expectTable")()
 	tab := Table{
 		meta: make(map[string]string),
 		rows: make(map[string]Row),
@@ -301,17 +513,38 @@ func (p *parser) expectTable() (string, Table) {
 	tok := p.peekTok()
 	if tok.typ == tLBRACE {
 		p.nextTok()
-		// there's a metatable
-		tab.meta = p.expectCells()
-		// TODO: handle metarow... ugh
-		// for now, just skip past to end of metatable
-		for {
-			if p.err != nil {
-				return toid, Table{}
+		if p.mode&SkipMetaTables != 0 {
+			// Skip the whole metatable without retaining its cells.
+			for {
+				tok = p.nextTok()
+				if tok.typ == tRBRACE {
+					break
+				}
+				if tok.typ == tEOF {
+					p.errorf(tok.pos, "Unexpected EOF in metatable")
+					return toid, tab
+				}
+				if p.err != nil {
+					return toid, Table{}
+				}
 			}
-			tok = p.nextTok()
-			if tok.typ == tRBRACE {
-				break
+		} else {
+			// there's a metatable
+			tab.meta = p.expectCells()
+			// TODO: handle metarow... ugh
+			// for now, just skip past to end of metatable
+			for {
+				if p.err != nil {
+					return toid, Table{}
+				}
+				tok = p.nextTok()
+				if tok.typ == tRBRACE {
+					break
+				}
+				if tok.typ == tEOF {
+					p.errorf(tok.pos, "Unexpected EOF in metatable")
+					return toid, tab
+				}
 			}
 		}
 	}
@@ -325,28 +558,45 @@ func (p *parser) expectTable() (string, Table) {
 		case tLSQUARE:
 			// row data
 			rowID, rowData := p.expectRow(tscope)
+			if p.mode&PreserveOrder != 0 {
+				if _, seen := tab.rows[rowID]; !seen {
+					tab.rowOrder = append(tab.rowOrder, rowID)
+				}
+			}
 			tab.rows[rowID] = rowData
 		case tNAME: // numeric
 			p.nextTok()
 			//TODO!!!
 			fmt.Printf("IGNORING row id\n")
+		default:
+			p.errorf(tok.pos, "Unexpected %s in table", &tok)
+		}
+
+		if p.err != nil {
+			return toid, tab
 		}
 	}
 }
 
-// Expect a group.
-// TODO: rethink this. we want to return a list of changes...
+// expectGroup parses a "@$${id{@ ... @$$}id}@" group (or its
+// "@$$}~~}@" abort form). Dict and table changes are staged in a txn
+// frame and only become visible once the group commits; an abort, a
+// mismatched commit ID, or hitting EOF mid-group discards them instead.
 func (p *parser) expectGroup() map[string]Table {
-	// TODO: need to avoid directly changing parser-global dicts here
-	// - a group abort won't roll back those changes!
-	p.expect(tGROUPSTART)
-	tabs := make(map[string]Table)
+	defer p.trace("expectGroup")()
+	start := p.expect(tGROUPSTART)
+	startID := start.val
+	t := p.pushTxn()
+
 	for {
 		tok := p.peekTok()
 		switch tok.typ {
 		case tEOF:
-			// treat as group abort.
-			return map[string]Table{}
+			// group never closed: treat as an abort, but record it as an
+			// error - unlike a real tGROUPABORT, this means the input was
+			// truncated and whatever was staged got thrown away.
+			p.errorf(tok.pos, "Unexpected EOF in group %q", startID)
+			return p.abortTxn()
 		case tLANGLE:
 			p.expectDict()
 		case tLSQUARE:
@@ -356,20 +606,28 @@ func (p *parser) expectGroup() map[string]Table {
 			//fmt.Printf("row: %q %q\n", rowID, rowData)
 		case tLBRACE:
 			toid, tab := p.expectTable()
-			tabs[toid] = tab
+			t.tabs[toid] = tab
+		case tGROUPSTART:
+			// nested group: its own txn frame merges into ours on commit.
+			for toid, tab := range p.expectGroup() {
+				t.tabs[toid] = tab
+			}
 		case tGROUPCOMMIT:
-			p.expect(tGROUPCOMMIT)
-			// TODO: check group ID matches tGROUPSTART one
-			return tabs
+			commit := p.expect(tGROUPCOMMIT)
+			if commit.val != startID {
+				p.errorf(commit.pos, "group commit id %q does not match start id %q", commit.val, startID)
+				return p.abortTxn()
+			}
+			return p.commitTxn()
 		case tGROUPABORT:
 			p.expect(tGROUPABORT)
-			return map[string]Table{}
+			return p.abortTxn()
 		default:
-			p.err = fmt.Errorf("%s: Unexpected %s", p.filename, tok)
+			p.errorf(tok.pos, "Unexpected %s", &tok)
 		}
 
 		if p.err != nil {
-			return tabs
+			return p.abortTxn()
 		}
 	}
 }
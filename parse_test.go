@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func parseString(t *testing.T, src string, mode Mode) (map[string]Table, error) {
+	t.Helper()
+	p := NewParser("<test>", newLexer([]byte(src)), mode)
+	return p.Parse()
+}
+
+func TestGroupCommitMergesDictAndTables(t *testing.T) {
+	src := `@$${01{@<(01=Ada)>{1:c[1(Name^01)]}@$$}01}@`
+	tabs, err := parseString(t, src, 0)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	tab, ok := tabs["1:c"]
+	if !ok {
+		t.Fatalf("table 1:c missing from %v", tabs)
+	}
+	if got := tab.rows["1"]["Name"]; got != "Ada" {
+		t.Errorf("Name = %q, want %q", got, "Ada")
+	}
+}
+
+func TestGroupAbortDiscardsTable(t *testing.T) {
+	src := `{1:c[1(Name=Ada)]}@$${02{@{2:c[1(Name=Bob)]}@$$}~~}@`
+	tabs, err := parseString(t, src, 0)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if _, ok := tabs["1:c"]; !ok {
+		t.Errorf("table 1:c (outside the group) should survive")
+	}
+	if _, ok := tabs["2:c"]; ok {
+		t.Errorf("table 2:c was staged inside an aborted group and should be discarded")
+	}
+}
+
+func TestGroupAbortRollsBackDict(t *testing.T) {
+	// The group rewrites alias 01 to "Bob" then aborts; the alias should
+	// still resolve to the value set before the group started.
+	src := `<(01=Ada)>@$${02{@<(01=Bob)>@$$}~~}@{1:c[1(Name^01)]}`
+	tabs, err := parseString(t, src, 0)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := tabs["1:c"].rows["1"]["Name"]; got != "Ada" {
+		t.Errorf("Name = %q, want %q (dict write inside aborted group leaked out)", got, "Ada")
+	}
+}
+
+func TestGroupEOFRecordsErrorAndDiscards(t *testing.T) {
+	// Group is never closed - input just stops mid-group.
+	src := `@$${01{@{1:c[1(Name=Ada)]}`
+
+	_, err := parseString(t, src, 0)
+	if err == nil {
+		t.Fatalf("Parse: expected an error for a group truncated by EOF")
+	}
+
+	tabs, err := parseString(t, src, Recover)
+	if err == nil {
+		t.Fatalf("Parse (Recover): expected an error for a group truncated by EOF")
+	}
+	if _, ok := tabs["1:c"]; ok {
+		t.Errorf("table 1:c was staged inside the truncated group and should be discarded")
+	}
+}
+
+func TestRecoverModeKeepsPartialResultsOnTruncatedTable(t *testing.T) {
+	src := `{1:c[1(Name=Ada)]}{2:c[1(Name=Bob)`
+
+	_, err := parseString(t, src, 0)
+	if err == nil {
+		t.Fatalf("Parse: expected an error for an unterminated table")
+	}
+
+	tabs, err := parseString(t, src, Recover)
+	if err == nil {
+		t.Fatalf("Parse (Recover): expected the truncated table's error to still be reported")
+	}
+	if _, ok := err.(ErrorList); !ok {
+		t.Fatalf("Parse (Recover): err = %T, want ErrorList", err)
+	}
+	if _, ok := tabs["1:c"]; !ok {
+		t.Errorf("table 1:c, parsed before the truncation, should still come back")
+	}
+}
+
+func TestPreserveOrderRecordsFileOrder(t *testing.T) {
+	src := `{1:c[3(Name=Carl)][1(Name=Ada)][2(Name=Bob)]}`
+
+	tabs, err := parseString(t, src, PreserveOrder)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	got := tabs["1:c"].RowOrder()
+	want := []string{"3", "1", "2"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("RowOrder() = %v, want %v", got, want)
+	}
+
+	// Without PreserveOrder, no order is recorded.
+	tabs, err = parseString(t, src, 0)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := tabs["1:c"].RowOrder(); got != nil {
+		t.Errorf("RowOrder() = %v, want nil without PreserveOrder", got)
+	}
+}
+
+func TestTraceModeDoesNotAffectResult(t *testing.T) {
+	src := `{1:c[1(Name=Ada)]}`
+	tabs, err := parseString(t, src, Trace)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := tabs["1:c"].rows["1"]["Name"]; got != "Ada" {
+		t.Errorf("Name = %q, want %q", got, "Ada")
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// ColumnType describes how a Mork column's string value should be
+// interpreted when decoding it into a Go struct field.
+type ColumnType int
+
+const (
+	TypeString ColumnType = iota
+	TypeInt
+	TypeUnixTime // a unix timestamp, stored as a string of digits
+	TypeURL
+)
+
+// Column maps a Mork row column (after dict resolution) onto a struct
+// field. Field defaults to Name if left blank.
+type Column struct {
+	Name  string
+	Type  ColumnType
+	Field string
+}
+
+func (c Column) fieldName() string {
+	if c.Field != "" {
+		return c.Field
+	}
+	return c.Name
+}
+
+// Schema describes the columns expected in a well-known Mork file, for
+// decoding into a slice of typed structs with Decode.
+type Schema struct {
+	Name    string
+	Columns []Column
+}
+
+// Well-known schemas for Thunderbird's Mork-backed profile files.
+var (
+	AddressBookSchema = Schema{
+		Name: "abook",
+		Columns: []Column{
+			{Name: "FirstName", Type: TypeString},
+			{Name: "LastName", Type: TypeString},
+			{Name: "PrimaryEmail", Type: TypeString, Field: "Email"},
+			{Name: "NickName", Type: TypeString, Field: "Nickname"},
+		},
+	}
+
+	HistorySchema = Schema{
+		Name: "history",
+		Columns: []Column{
+			{Name: "URL", Type: TypeURL},
+			{Name: "Name", Type: TypeString, Field: "Title"},
+			{Name: "LastVisitDate", Type: TypeUnixTime, Field: "VisitDate"},
+		},
+	}
+
+	FormHistorySchema = Schema{
+		Name: "formhistory",
+		Columns: []Column{
+			{Name: "Name"},
+			{Name: "Value"},
+		},
+	}
+
+	PanaceaSchema = Schema{
+		Name: "panacea",
+		Columns: []Column{
+			{Name: "Name"},
+		},
+	}
+
+	knownSchemas = []Schema{AddressBookSchema, HistorySchema, FormHistorySchema, PanaceaSchema}
+)
+
+// AddressBookEntry is a decoded row from abook.mab.
+type AddressBookEntry struct {
+	FirstName string
+	LastName  string
+	Email     string
+	Nickname  string
+}
+
+// HistoryEntry is a decoded row from history.dat.
+type HistoryEntry struct {
+	URL       string
+	Title     string
+	VisitDate int64
+}
+
+// FormHistoryEntry is a decoded row from formhistory.dat.
+type FormHistoryEntry struct {
+	Name  string
+	Value string
+}
+
+// PanaceaEntry is a decoded row from panacea.dat.
+type PanaceaEntry struct {
+	Name string
+}
+
+// Decode walks every row of every table in tabs and appends a matching
+// entry to out, which must be a pointer to a slice. Columns not present
+// in sc are ignored; columns in sc not present in a row are left zero.
+func Decode(tabs map[string]Table, sc Schema, out interface{}) error {
+	ptr := reflect.ValueOf(out)
+	if ptr.Kind() != reflect.Ptr || ptr.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("schema: out must be a pointer to a slice, got %T", out)
+	}
+	slice := ptr.Elem()
+	elemType := slice.Type().Elem()
+
+	for _, tab := range tabs {
+		for _, row := range tab.rows {
+			elem := reflect.New(elemType).Elem()
+			for _, col := range sc.Columns {
+				raw, ok := row[col.Name]
+				if !ok {
+					continue
+				}
+				field := elem.FieldByName(col.fieldName())
+				if !field.IsValid() || !field.CanSet() {
+					continue
+				}
+				if err := setField(field, col.Type, raw); err != nil {
+					return fmt.Errorf("schema %s, column %s: %w", sc.Name, col.Name, err)
+				}
+			}
+			slice.Set(reflect.Append(slice, elem))
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, typ ColumnType, raw string) error {
+	switch typ {
+	case TypeString, TypeURL:
+		field.SetString(raw)
+	case TypeInt, TypeUnixTime:
+		if raw == "" {
+			return nil
+		}
+		// Mork stores numeric cells as plain decimal or 0x-prefixed hex.
+		n, err := strconv.ParseInt(raw, 0, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	default:
+		return fmt.Errorf("unknown column type %d", typ)
+	}
+	return nil
+}
+
+// Detect makes a best-effort guess at which known schema tabs matches,
+// based on which column names appear anywhere in it. It's a fingerprint,
+// not a guarantee - callers dealing with an unfamiliar profile should
+// still sanity-check the result.
+func Detect(tabs map[string]Table) (Schema, bool) {
+	cols := map[string]bool{}
+	for _, tab := range tabs {
+		for _, row := range tab.rows {
+			for name := range row {
+				cols[name] = true
+			}
+		}
+	}
+
+	for _, sc := range knownSchemas {
+		matched := 0
+		for _, col := range sc.Columns {
+			if cols[col.Name] {
+				matched++
+			}
+		}
+		if matched == len(sc.Columns) {
+			return sc, true
+		}
+	}
+	return Schema{}, false
+}
+
+// DecodeAddressBook decodes tabs as a Thunderbird address book.
+func DecodeAddressBook(tabs map[string]Table) ([]AddressBookEntry, error) {
+	var out []AddressBookEntry
+	err := Decode(tabs, AddressBookSchema, &out)
+	return out, err
+}
+
+// DecodeHistory decodes tabs as a Thunderbird history.dat.
+func DecodeHistory(tabs map[string]Table) ([]HistoryEntry, error) {
+	var out []HistoryEntry
+	err := Decode(tabs, HistorySchema, &out)
+	return out, err
+}
+
+// DecodeFormHistory decodes tabs as a Thunderbird formhistory.dat.
+func DecodeFormHistory(tabs map[string]Table) ([]FormHistoryEntry, error) {
+	var out []FormHistoryEntry
+	err := Decode(tabs, FormHistorySchema, &out)
+	return out, err
+}
+
+// DecodePanacea decodes tabs as a Thunderbird panacea.dat.
+func DecodePanacea(tabs map[string]Table) ([]PanaceaEntry, error) {
+	var out []PanaceaEntry
+	err := Decode(tabs, PanaceaSchema, &out)
+	return out, err
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestDecodeAddressBook(t *testing.T) {
+	tabs := map[string]Table{
+		"1:c": {
+			rows: map[string]Row{
+				"1": {
+					"FirstName":    "Ada",
+					"LastName":     "Lovelace",
+					"PrimaryEmail": "ada@example.com",
+				},
+			},
+		},
+	}
+
+	entries, err := DecodeAddressBook(tabs)
+	if err != nil {
+		t.Fatalf("DecodeAddressBook: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	got := entries[0]
+	want := AddressBookEntry{FirstName: "Ada", LastName: "Lovelace", Email: "ada@example.com"}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tabs := map[string]Table{
+		"1:c": {
+			rows: map[string]Row{
+				"1": {"Name": "q", "Value": "golang"},
+			},
+		},
+	}
+	sc, ok := Detect(tabs)
+	if !ok || sc.Name != "formhistory" {
+		t.Errorf("Detect() = %+v, %v, want formhistory schema", sc, ok)
+	}
+}